@@ -0,0 +1,126 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractURLs(t *testing.T) {
+	body := `
+<html>
+<head>
+	<link rel="stylesheet" href="style.css">
+	<style>body { background: url('bg.png'); }</style>
+	<script src="app.js"></script>
+</head>
+<body>
+	<a href="page2.html">next</a>
+	<img src="logo.png" srcset="logo-1x.png 1x, logo-2x.png 2x">
+	<iframe src="embed.html"></iframe>
+	<source src="clip.mp4">
+</body>
+</html>`
+	want := []link{
+		{URL: "style.css", Kind: linkRelated},
+		{URL: "bg.png", Kind: linkRelated},
+		{URL: "app.js", Kind: linkRelated},
+		{URL: "page2.html", Kind: linkPrimary},
+		{URL: "logo.png", Kind: linkRelated},
+		{URL: "logo-1x.png", Kind: linkRelated},
+		{URL: "logo-2x.png", Kind: linkRelated},
+		{URL: "embed.html", Kind: linkRelated},
+		{URL: "clip.mp4", Kind: linkRelated},
+	}
+	got := extractURLs(body)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("extractURLs() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtractTagLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want []link
+	}{
+		{name: "anchor is primary", html: `<a href="a.html">`, want: []link{{URL: "a.html", Kind: linkPrimary}}},
+		{name: "link is related", html: `<link href="a.css">`, want: []link{{URL: "a.css", Kind: linkRelated}}},
+		{name: "img is related", html: `<img src="a.png">`, want: []link{{URL: "a.png", Kind: linkRelated}}},
+		{name: "script is related", html: `<script src="a.js">`, want: []link{{URL: "a.js", Kind: linkRelated}}},
+		{name: "iframe is related", html: `<iframe src="a.html">`, want: []link{{URL: "a.html", Kind: linkRelated}}},
+		{name: "source is related", html: `<source src="a.mp4">`, want: []link{{URL: "a.mp4", Kind: linkRelated}}},
+		{name: "missing attribute yields nothing", html: `<a>`, want: nil},
+		{name: "empty attribute yields nothing", html: `<a href="">`, want: nil},
+		{name: "unrelated tag yields nothing", html: `<div>`, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractURLs(tt.html)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractURLs(%q) = %+v, want %+v", tt.html, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSrcset(t *testing.T) {
+	tests := []struct {
+		name string
+		v    string
+		want []string
+	}{
+		{name: "two candidates with descriptors", v: "a.jpg 1x, b.jpg 2x", want: []string{"a.jpg", "b.jpg"}},
+		{name: "width descriptors", v: "small.jpg 480w, large.jpg 1080w", want: []string{"small.jpg", "large.jpg"}},
+		{name: "no descriptor", v: "a.jpg", want: []string{"a.jpg"}},
+		{name: "empty candidates are skipped", v: "a.jpg 1x, , b.jpg 2x", want: []string{"a.jpg", "b.jpg"}},
+		{name: "empty string", v: "", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSrcset(tt.v); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSrcset(%q) = %v, want %v", tt.v, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractCSSURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want []link
+	}{
+		{
+			name: "single quoted url",
+			css:  `.a { background: url('bg.png'); }`,
+			want: []link{{URL: "bg.png", Kind: linkRelated}},
+		},
+		{
+			name: "double quoted url",
+			css:  `.a { background: url("bg.png"); }`,
+			want: []link{{URL: "bg.png", Kind: linkRelated}},
+		},
+		{
+			name: "unquoted url",
+			css:  `.a { background: url(bg.png); }`,
+			want: []link{{URL: "bg.png", Kind: linkRelated}},
+		},
+		{
+			name: "multiple urls",
+			css:  `.a { background: url(a.png); } .b { background: url(b.png); }`,
+			want: []link{{URL: "a.png", Kind: linkRelated}, {URL: "b.png", Kind: linkRelated}},
+		},
+		{
+			name: "no urls",
+			css:  `.a { color: red; }`,
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractCSSURLs(tt.css); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractCSSURLs(%q) = %+v, want %+v", tt.css, got, tt.want)
+			}
+		})
+	}
+}
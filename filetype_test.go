@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestMatchesFiletype(t *testing.T) {
+	tests := []struct {
+		name      string
+		rawUrl    string
+		fileTypes []string
+		want      bool
+	}{
+		{name: "matches single extension", rawUrl: "http://example.com/a.zip", fileTypes: []string{".zip"}, want: true},
+		{name: "matches one of several", rawUrl: "http://example.com/a.tar.gz", fileTypes: []string{".zip", ".tar.gz"}, want: true},
+		{name: "no match", rawUrl: "http://example.com/a.txt", fileTypes: []string{".zip", ".iso"}, want: false},
+		{name: "empty list never matches", rawUrl: "http://example.com/a.zip", fileTypes: nil, want: false},
+		{name: "extension must be a suffix", rawUrl: "http://example.com/a.zip.html", fileTypes: []string{".zip"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesFiletype(tt.rawUrl, tt.fileTypes); got != tt.want {
+				t.Errorf("matchesFiletype(%q, %v) = %v, want %v", tt.rawUrl, tt.fileTypes, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesMime(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		prefixes    []string
+		want        bool
+	}{
+		{name: "matches with params", contentType: "image/png; charset=binary", prefixes: []string{"image/"}, want: true},
+		{name: "matches one of several prefixes", contentType: "application/pdf", prefixes: []string{"image/", "application/pdf"}, want: true},
+		{name: "no match", contentType: "text/html", prefixes: []string{"image/", "application/pdf"}, want: false},
+		{name: "empty list never matches", contentType: "image/png", prefixes: nil, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesMime(tt.contentType, tt.prefixes); got != tt.want {
+				t.Errorf("matchesMime(%q, %v) = %v, want %v", tt.contentType, tt.prefixes, got, tt.want)
+			}
+		})
+	}
+}
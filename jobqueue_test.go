@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestJobQueueFIFO(t *testing.T) {
+	q := newJobQueue()
+	q.push(crawlJob{raw: "a"})
+	q.push(crawlJob{raw: "b"})
+
+	j, ok := q.pop()
+	if !ok || j.raw != "a" {
+		t.Fatalf("pop() = %+v, %v, want {raw:a}, true", j, ok)
+	}
+	j, ok = q.pop()
+	if !ok || j.raw != "b" {
+		t.Fatalf("pop() = %+v, %v, want {raw:b}, true", j, ok)
+	}
+}
+
+func TestJobQueuePopBlocksUntilPush(t *testing.T) {
+	q := newJobQueue()
+	done := make(chan crawlJob, 1)
+	go func() {
+		j, ok := q.pop()
+		if !ok {
+			return
+		}
+		done <- j
+	}()
+	q.push(crawlJob{raw: "late"})
+	if j := <-done; j.raw != "late" {
+		t.Fatalf("got %+v, want raw=late", j)
+	}
+}
+
+func TestJobQueueCloseUnblocksPop(t *testing.T) {
+	q := newJobQueue()
+	var wg sync.WaitGroup
+	results := make([]bool, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, ok := q.pop()
+			results[i] = ok
+		}(i)
+	}
+	q.closeQueue()
+	wg.Wait()
+	for i, ok := range results {
+		if ok {
+			t.Errorf("pop() #%d returned ok=true after close, want false", i)
+		}
+	}
+}
@@ -1,7 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,30 +18,80 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
 	"regexp"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+	"golang.org/x/net/html"
+	"golang.org/x/term"
+	"golang.org/x/time/rate"
+)
+
+// downloadStatus records where a file download stands, so a resumed crawl
+// can tell a completed download from one that crashed mid-transfer.
+type downloadStatus string
+
+const (
+	downloadInProgress downloadStatus = "in-progress"
+	downloadDone       downloadStatus = "done"
+	downloadFailed     downloadStatus = "failed"
 )
 
+// stateEvent is a single line of the on-disk write-ahead log: either a
+// page visit or a download status change. Replaying every event on
+// startup reconstructs the in-memory visitTracker state.
+type stateEvent struct {
+	Visit    string         `json:"visit,omitempty"`
+	Download string         `json:"download,omitempty"`
+	Status   downloadStatus `json:"status,omitempty"`
+	Err      string         `json:"err,omitempty"`
+}
+
 type visitTracker struct {
-	mtx *sync.Mutex
-	v   map[string]bool
+	mtx       *sync.Mutex
+	v         map[string]bool
+	downloads map[string]downloadStatus
+	wal       *os.File
 }
 
 var (
-	webRoot   = flag.String("root", "", "Root web url to crawl")
-	ft        = flag.String("filetype", ".zip", "Filetype to look for")
-	filter    = flag.String("filter", "", "Regex filter to apply to URL")
-	outputDir = flag.String("output", "", "Optional output directory")
-	simulate  = flag.Bool("s", false, "Simulate, just print URL we would download")
+	webRoot      = flag.String("root", "", "Root web url to crawl")
+	filetypes    = flag.String("filetypes", ".zip", "Comma-separated list of file extensions to download (e.g. .zip,.tar.gz,.iso)")
+	mimeTypes    = flag.String("mime", "", "Comma-separated list of Content-Type prefixes to match when a URL has no useful extension (e.g. application/pdf,image/)")
+	filter       = flag.String("filter", "", "Regex filter to apply to URL")
+	outputDir    = flag.String("output", "", "Optional output directory")
+	simulate     = flag.Bool("s", false, "Simulate, just print URL we would download")
+	concurrency  = flag.Int("concurrency", 4, "Number of concurrent crawl workers")
+	rps          = flag.Float64("rps", 2.0, "Max requests per second, per host")
+	statePath    = flag.String("state", "", "Optional path to a checkpoint file for resumable crawls")
+	useRobots    = flag.Bool("robots", true, "Fetch robots.txt for sitemap seeds and Disallow rules")
+	useSitemap   = flag.Bool("sitemap", false, "Fetch sitemap.xml for additional seed URLs")
+	seedsFile    = flag.String("seeds", "", "Optional file of newline-separated seed URLs")
+	ignoreRobots = flag.Bool("ignore-robots", false, "Ignore robots.txt Disallow rules")
+	retries      = flag.Int("retries", 3, "Number of retries, with exponential backoff, on a failed download")
+	checksumsArg = flag.String("checksums", "", "Optional file mapping URL to sha256 checksum, verified after each download")
+	subdomains   = flag.Bool("subdomains", false, "Also allow crawling *.<root-host> subdomains")
+	maxDepth     = flag.Int("max-depth", 0, "Maximum crawl depth from the root page (0 = unlimited)")
+	includeArg   = flag.String("include", "", "Comma-separated regexes a URL must match at least one of to be crawled")
+	excludeArg   = flag.String("exclude", "", "Comma-separated regexes that exclude a matching URL from the crawl")
 
 	errInvalidResponse = errors.New("Invalid HTTP response")
 	outDir             = "./"
 	host               string
+	activeRobots       *robotsRules
+	fileTypeList       []string
+	mimeTypeList       []string
 )
 
-func init() {
+// parseFlags parses the command-line flags and validates/derives the
+// package-level settings that depend on them. Separate from init() so
+// `go test` doesn't parse flags (and fail validation) before main() runs.
+func parseFlags() {
 	flag.Parse()
 	if *webRoot == "" {
 		log.Fatal("I require a webroot")
@@ -41,12 +99,43 @@ func init() {
 	if *outputDir != "" {
 		outDir = *outputDir
 	}
+	fileTypeList = splitCSV(*filetypes)
+	mimeTypeList = splitCSV(*mimeTypes)
+}
+
+// splitCSV splits a comma-separated flag value into its trimmed,
+// non-empty entries.
+func splitCSV(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
 }
 
 func main() {
+	parseFlags()
+
 	var re *regexp.Regexp
 	var err error
-	visited := newVisitTracker()
+	visited, err := newVisitTracker(*statePath)
+	if err != nil {
+		log.Fatal("Failed to load crawl state", err)
+	}
+	defer visited.Close()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGINT)
+	go func() {
+		<-sigCh
+		fmt.Println("\nInterrupted, flushing state...")
+		visited.Close()
+		os.Exit(1)
+	}()
+
 	if *filter != "" {
 		re, err = regexp.CompilePOSIX(*filter)
 		if err != nil {
@@ -60,112 +149,865 @@ func main() {
 	}
 	host = url.Scheme + "://" + url.Host
 
+	scope, err := newScope(url.Host, *subdomains, *maxDepth, splitCSV(*includeArg), splitCSV(*excludeArg))
+	if err != nil {
+		log.Fatal("Failed to compile scope patterns", err)
+	}
+
+	var checksums map[string]string
+	if *checksumsArg != "" {
+		var err error
+		checksums, err = loadChecksumsFile(*checksumsArg)
+		if err != nil {
+			log.Fatal("Failed to load checksums file", err)
+		}
+	}
+	f := newFetcher(*concurrency, *rps, *retries, checksums)
+
+	var extraSeeds []string
+	if *useRobots {
+		robots, sitemaps, err := fetchRobots(f, host)
+		if err != nil {
+			fmt.Printf("Failed to fetch robots.txt: %v\n", err)
+		} else {
+			activeRobots = robots
+			for _, sm := range sitemaps {
+				smUrls, err := fetchSitemap(f, sm, 0)
+				if err != nil {
+					fmt.Printf("Failed to fetch sitemap %s: %v\n", sm, err)
+					continue
+				}
+				extraSeeds = append(extraSeeds, smUrls...)
+			}
+		}
+	}
+	if *useSitemap {
+		smUrls, err := fetchSitemap(f, host+"/sitemap.xml", 0)
+		if err != nil {
+			fmt.Printf("Failed to fetch sitemap.xml: %v\n", err)
+		} else {
+			extraSeeds = append(extraSeeds, smUrls...)
+		}
+	}
+	if *seedsFile != "" {
+		fileSeeds, err := loadSeedsFile(*seedsFile)
+		if err != nil {
+			log.Fatal("Failed to load seeds file", err)
+		}
+		extraSeeds = append(extraSeeds, fileSeeds...)
+	}
+
 	//probe root page
-	rootPage, err := getPage(*webRoot)
+	rootPage, err := f.getPage(*webRoot)
 	if err != nil {
 		log.Fatal("Failed to get root page", err)
 	}
 
 	urls := extractURLs(rootPage)
-	walkUrls(visited, urls, *webRoot, re, *ft)
+	for _, seedUrl := range extraSeeds {
+		urls = append(urls, link{URL: seedUrl, Kind: linkPrimary})
+	}
+	crawl(visited, f, urls, *webRoot, re, fileTypeList, mimeTypeList, scope, *concurrency)
+}
+
+// linkKind classifies a discovered link as either crawlable (primary) or
+// fetch-only (related), so the crawler doesn't try to recurse into things
+// like images or scripts.
+type linkKind int
+
+const (
+	linkPrimary linkKind = iota // anchors: candidates for recursion/download
+	linkRelated                 // stylesheets, images, scripts, etc: fetch only
+)
+
+// link is a single URL discovered on a page, tagged with how the crawler
+// should treat it.
+type link struct {
+	URL  string
+	Kind linkKind
+}
+
+// tagAttr pairs an HTML tag with the attribute that carries its URL, and
+// the linkKind that attribute should be tagged with.
+type tagAttr struct {
+	Tag  string
+	Attr string
+	Kind linkKind
+}
+
+// extractTags lists every tag/attribute combination extractURLs looks for.
+// srcset is handled separately since it can carry multiple comma-separated
+// URLs rather than a single one.
+var extractTags = []tagAttr{
+	{Tag: "a", Attr: "href", Kind: linkPrimary},
+	{Tag: "link", Attr: "href", Kind: linkRelated},
+	{Tag: "img", Attr: "src", Kind: linkRelated},
+	{Tag: "script", Attr: "src", Kind: linkRelated},
+	{Tag: "iframe", Attr: "src", Kind: linkRelated},
+	{Tag: "source", Attr: "src", Kind: linkRelated},
+}
+
+var cssURLRe = regexp.MustCompile(`url\(["']?([^'")]+)["']?\)`)
+
+// extractURLs tokenizes body as HTML and returns every link it can find:
+// anchors, stylesheet/image/script/iframe/source references, srcset
+// candidates, and url(...) references inside inline <style> blocks.
+func extractURLs(body string) []link {
+	var links []link
+	inStyle := false
+	z := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if tok.Data == "style" {
+				inStyle = true
+			}
+			links = append(links, extractTagLinks(tok)...)
+		case html.EndTagToken:
+			tok := z.Token()
+			if tok.Data == "style" {
+				inStyle = false
+			}
+		case html.TextToken:
+			if inStyle {
+				links = append(links, extractCSSURLs(string(z.Text()))...)
+			}
+		}
+	}
 }
 
-func extractURLs(body string) []string {
-	urls := []string{}
-	re := regexp.MustCompile(`<A HREF="([^"]+)">`)
-	match := re.FindAllStringSubmatch(body, -1)
-	for i := range match {
-		if len(match[i]) != 2 {
+// extractTagLinks pulls the configured attribute off tok for every matching
+// entry in extractTags, plus srcset candidates when present.
+func extractTagLinks(tok html.Token) []link {
+	var links []link
+	for _, ta := range extractTags {
+		if tok.Data != ta.Tag {
 			continue
 		}
-		urls = append(urls, match[i][1])
+		if v, ok := attrVal(tok, ta.Attr); ok && v != "" {
+			links = append(links, link{URL: v, Kind: ta.Kind})
+		}
+	}
+	if v, ok := attrVal(tok, "srcset"); ok {
+		for _, u := range parseSrcset(v) {
+			links = append(links, link{URL: u, Kind: linkRelated})
+		}
+	}
+	return links
+}
+
+func attrVal(tok html.Token, name string) (string, bool) {
+	for _, a := range tok.Attr {
+		if a.Key == name {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// parseSrcset splits a srcset attribute ("a.jpg 1x, b.jpg 2x") into its
+// bare URLs, dropping the descriptor.
+func parseSrcset(v string) []string {
+	var urls []string
+	for _, candidate := range strings.Split(v, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "" {
+			continue
+		}
+		fields := strings.Fields(candidate)
+		if len(fields) > 0 {
+			urls = append(urls, fields[0])
+		}
 	}
 	return urls
 }
 
-func getUrlsFromPage(url string) ([]string, error) {
-	body, err := getPage(url)
+// extractCSSURLs finds every url(...) reference in a CSS blob. extractURLs
+// calls it for the text of inline <style> blocks; standalone text/css
+// responses are not parsed for url(...) references.
+func extractCSSURLs(css string) []link {
+	var links []link
+	for _, match := range cssURLRe.FindAllStringSubmatch(css, -1) {
+		if len(match) != 2 {
+			continue
+		}
+		links = append(links, link{URL: match[1], Kind: linkRelated})
+	}
+	return links
+}
+
+func getUrlsFromPage(f *fetcher, url string) ([]link, error) {
+	body, err := f.getPage(url)
 	if err != nil {
 		return nil, err
 	}
 	return extractURLs(body), nil
 }
 
-func walkUrls(vt *visitTracker, urls []string, root string, filter *regexp.Regexp, ft string) {
-	for i := range urls {
-		var newUrl string
-		u, err := url.ParseRequestURI(urls[i])
+// Scope bounds how far and how wide a crawl is allowed to range: which
+// hosts it may touch, how many links deep it may recurse, and which
+// candidate URLs are admitted to (or barred from) the frontier.
+type Scope struct {
+	AllowedHosts    []string
+	Subdomains      bool
+	MaxDepth        int
+	IncludePatterns []*regexp.Regexp
+	ExcludePatterns []*regexp.Regexp
+}
+
+// newScope builds a Scope rooted at rootHost, compiling the given include
+// and exclude patterns.
+func newScope(rootHost string, subdomains bool, maxDepth int, includes, excludes []string) (*Scope, error) {
+	s := &Scope{
+		AllowedHosts: []string{rootHost},
+		Subdomains:   subdomains,
+		MaxDepth:     maxDepth,
+	}
+	for _, p := range includes {
+		re, err := regexp.Compile(p)
 		if err != nil {
-			fmt.Printf("Bad URL: %s\n", urls[i])
+			return nil, err
+		}
+		s.IncludePatterns = append(s.IncludePatterns, re)
+	}
+	for _, p := range excludes {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		s.ExcludePatterns = append(s.ExcludePatterns, re)
+	}
+	return s, nil
+}
+
+// hostAllowed reports whether h is one of the allowed hosts, or a
+// subdomain of one when Subdomains is set.
+func (s *Scope) hostAllowed(h string) bool {
+	for _, allowed := range s.AllowedHosts {
+		if h == allowed {
+			return true
+		}
+		if s.Subdomains && strings.HasSuffix(h, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether rawUrl at the given crawl depth is in scope: its
+// host is allowed, it isn't past MaxDepth, it doesn't match an exclude
+// pattern, and it matches at least one include pattern when any are set.
+// A nil Scope allows everything, preserving the tool's old unbounded
+// behavior.
+func (s *Scope) allowed(rawUrl string, depth int) bool {
+	if s == nil {
+		return true
+	}
+	if s.MaxDepth > 0 && depth > s.MaxDepth {
+		return false
+	}
+	if u, err := url.Parse(rawUrl); err == nil && u.Host != "" && !s.hostAllowed(u.Host) {
+		return false
+	}
+	for _, re := range s.ExcludePatterns {
+		if re.MatchString(rawUrl) {
+			return false
+		}
+	}
+	if len(s.IncludePatterns) == 0 {
+		return true
+	}
+	for _, re := range s.IncludePatterns {
+		if re.MatchString(rawUrl) {
+			return true
+		}
+	}
+	return false
+}
+
+// robotsRules holds the parsed "User-agent: *" Disallow paths found in a
+// robots.txt file. Sitemap directives are returned separately by
+// fetchRobots.
+type robotsRules struct {
+	disallow []string
+}
+
+// allowed reports whether urlPath is permitted by the Disallow rules.
+func (r *robotsRules) allowed(urlPath string) bool {
+	if r == nil {
+		return true
+	}
+	for _, d := range r.disallow {
+		if strings.HasPrefix(urlPath, d) {
+			return false
+		}
+	}
+	return true
+}
+
+// robotsAllowed checks rawUrl against the active robots.txt rules, honored
+// by default; -ignore-robots opts out.
+func robotsAllowed(rawUrl string) bool {
+	if *ignoreRobots || activeRobots == nil {
+		return true
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return true
+	}
+	return activeRobots.allowed(u.Path)
+}
+
+// fetchRobots retrieves and parses "<host>/robots.txt", returning the
+// User-agent: * Disallow rules and any Sitemap: URLs it references.
+func fetchRobots(f *fetcher, host string) (*robotsRules, []string, error) {
+	body, err := f.getPage(host + "/robots.txt")
+	if err != nil {
+		return nil, nil, err
+	}
+	rules := &robotsRules{}
+	var sitemaps []string
+	applies := false
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		if u.String() == "/" {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
 			continue
 		}
-		if strings.HasSuffix(root, u.String()) {
-			//parent URL, skipping
-			fmt.Printf("Skipping parent: %s\n", u.String())
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		val := strings.TrimSpace(parts[1])
+		switch key {
+		case "user-agent":
+			applies = val == "*"
+		case "disallow":
+			if applies && val != "" {
+				rules.disallow = append(rules.disallow, val)
+			}
+		case "sitemap":
+			sitemaps = append(sitemaps, val)
+		}
+	}
+	return rules, sitemaps, nil
+}
+
+// sitemapURL is a single <url> or <sitemap> entry's <loc> child, shared by
+// both the urlset and sitemapindex schemas.
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemapURLSet struct {
+	URLs []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// maxSitemapDepth bounds recursion through chained sitemap-index files.
+const maxSitemapDepth = 5
+
+// fetchSitemap retrieves sitemapUrl and returns every page URL it lists,
+// recursing into child sitemaps when it's a sitemap-index document.
+func fetchSitemap(f *fetcher, sitemapUrl string, depth int) ([]string, error) {
+	if depth > maxSitemapDepth {
+		return nil, nil
+	}
+	body, err := f.getPage(sitemapUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	var uset sitemapURLSet
+	if err := xml.Unmarshal([]byte(body), &uset); err == nil && len(uset.URLs) > 0 {
+		urls := make([]string, 0, len(uset.URLs))
+		for _, u := range uset.URLs {
+			urls = append(urls, u.Loc)
+		}
+		return urls, nil
+	}
+
+	var idx sitemapIndex
+	if err := xml.Unmarshal([]byte(body), &idx); err == nil && len(idx.Sitemaps) > 0 {
+		var urls []string
+		for _, sm := range idx.Sitemaps {
+			childUrls, err := fetchSitemap(f, sm.Loc, depth+1)
+			if err != nil {
+				fmt.Printf("Failed to fetch sitemap %s: %v\n", sm.Loc, err)
+				continue
+			}
+			urls = append(urls, childUrls...)
+		}
+		return urls, nil
+	}
+
+	return nil, nil
+}
+
+// matchesFiletype reports whether rawUrl ends in one of fileTypes.
+func matchesFiletype(rawUrl string, fileTypes []string) bool {
+	for _, ext := range fileTypes {
+		if strings.HasSuffix(rawUrl, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMime reports whether contentType starts with one of prefixes,
+// e.g. "image/" matching "image/png; charset=binary".
+func matchesMime(contentType string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(contentType, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUsefulExtension reports whether u's path carries a file extension,
+// used to decide whether a MIME probe is worth the extra request.
+func hasUsefulExtension(u *url.URL) bool {
+	return path.Ext(u.Path) != ""
+}
+
+// loadSeedsFile reads a newline-separated list of seed URLs, ignoring
+// blank lines and "#" comments.
+func loadSeedsFile(seedsPath string) ([]string, error) {
+	data, err := os.ReadFile(seedsPath)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
-		if strings.HasPrefix(u.String(), `/`) {
-			//not a relative URL, correct
-			newUrl = host + urls[i]
-		} else {
-			//relative URL
-			newUrl = root + urls[i]
-		}
-		if !vt.Visited(newUrl) {
-			vt.Visit(newUrl)
-			//check if we recurse into it, or grab a file
-			if strings.HasSuffix(urls[i], `/`) {
-				//recurse in
-				childUrls, err := getUrlsFromPage(newUrl)
-				if err != nil {
-					fmt.Printf("Failed to get %s: %v\n", newUrl, err)
-					continue
-				}
-				walkUrls(vt, childUrls, newUrl, filter, ft)
-			} else if strings.HasSuffix(urls[i], ft) {
-				if filter != nil {
-					if !filter.MatchString(newUrl) {
-						continue
-					}
-				}
-				fmt.Printf("Downloading %s ...", urls[i])
-				if err := downloadFile(newUrl, urls[i], outDir); err != nil {
-					fmt.Printf("Failed: %v\n", err)
-				} else {
-					fmt.Printf("DONE\n")
+		urls = append(urls, line)
+	}
+	return urls, nil
+}
+
+// loadChecksumsFile reads a "<url> <sha256>" per line checksum table used
+// to verify downloads with -checksums.
+func loadChecksumsFile(checksumsPath string) (map[string]string, error) {
+	data, err := os.ReadFile(checksumsPath)
+	if err != nil {
+		return nil, err
+	}
+	sums := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		sums[fields[0]] = fields[1]
+	}
+	return sums, nil
+}
+
+// crawlJob is a single queued unit of work: a link discovered on root,
+// still carrying its raw (unresolved) href, whether it's primary
+// (crawlable) or related (fetch-only), and its depth from the seed.
+type crawlJob struct {
+	raw   string
+	root  string
+	kind  linkKind
+	depth int
+}
+
+// jobQueue is an unbounded FIFO frontier shared by the worker pool.
+// Unlike a fixed-capacity channel, push never blocks, so a worker can
+// always enqueue the links it just discovered without needing another
+// worker free to drain the queue first.
+type jobQueue struct {
+	mtx    sync.Mutex
+	cond   *sync.Cond
+	items  []crawlJob
+	closed bool
+}
+
+func newJobQueue() *jobQueue {
+	q := &jobQueue{}
+	q.cond = sync.NewCond(&q.mtx)
+	return q
+}
+
+func (q *jobQueue) push(j crawlJob) {
+	q.mtx.Lock()
+	q.items = append(q.items, j)
+	q.mtx.Unlock()
+	q.cond.Signal()
+}
+
+// pop blocks until a job is available or the queue is closed, reporting
+// false in the latter case.
+func (q *jobQueue) pop() (crawlJob, bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.items) == 0 {
+		return crawlJob{}, false
+	}
+	j := q.items[0]
+	q.items = q.items[1:]
+	return j, true
+}
+
+// closeQueue wakes every blocked pop so the worker pool can shut down.
+func (q *jobQueue) closeQueue() {
+	q.mtx.Lock()
+	q.closed = true
+	q.mtx.Unlock()
+	q.cond.Broadcast()
+}
+
+// crawl drives the frontier with a bounded pool of workers instead of
+// recursing: workers pull jobs off an unbounded queue and push newly
+// discovered links back onto it, and a pending-work counter tracks when
+// the frontier has drained so the pool can shut down cleanly.
+func crawl(vt *visitTracker, f *fetcher, seed []link, root string, filter *regexp.Regexp, fileTypes, mimePrefixes []string, scope *Scope, workers int) {
+	jobs := newJobQueue()
+	var pending sync.WaitGroup
+
+	enqueue := func(j crawlJob) {
+		pending.Add(1)
+		jobs.push(j)
+	}
+
+	var pool sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		pool.Add(1)
+		go func() {
+			defer pool.Done()
+			for {
+				j, ok := jobs.pop()
+				if !ok {
+					return
 				}
+				processJob(vt, f, j, filter, fileTypes, mimePrefixes, scope, enqueue)
+				pending.Done()
 			}
+		}()
+	}
+
+	for i := range seed {
+		enqueue(crawlJob{raw: seed[i].URL, root: root, kind: seed[i].Kind, depth: 0})
+	}
+
+	pending.Wait()
+	jobs.closeQueue()
+	pool.Wait()
+}
+
+// processJob resolves a single job's URL against its root, then either
+// recurses (by enqueueing the child page's links) or downloads it.
+func processJob(vt *visitTracker, f *fetcher, j crawlJob, filter *regexp.Regexp, fileTypes, mimePrefixes []string, scope *Scope, enqueue func(crawlJob)) {
+	base, err := url.Parse(j.root)
+	if err != nil {
+		fmt.Printf("Bad root URL: %s\n", j.root)
+		return
+	}
+	ref, err := url.Parse(j.raw)
+	if err != nil {
+		fmt.Printf("Bad URL: %s\n", j.raw)
+		return
+	}
+	//resolve against the page it was found on per RFC 3986, so plain
+	//relative refs ("style.css", "../img/logo.png") work the same as
+	//root-relative ("/img/logo.png") and fully-qualified ones
+	resolved := base.ResolveReference(ref)
+	resolved.Fragment = ""
+	newUrl := resolved.String()
+	if newUrl == j.root {
+		//link back to the page it was found on, skip
+		return
+	}
+	if !robotsAllowed(newUrl) {
+		fmt.Printf("Skipping (robots.txt disallow): %s\n", newUrl)
+		return
+	}
+	if !scope.allowed(newUrl, j.depth) {
+		fmt.Printf("Skipping (out of scope): %s\n", newUrl)
+		return
+	}
+	isDownload := matchesFiletype(j.raw, fileTypes)
+	if !isDownload && len(mimePrefixes) > 0 && !hasUsefulExtension(resolved) {
+		contentType, err := f.contentType(newUrl)
+		if err != nil {
+			fmt.Printf("Failed to probe %s: %v\n", newUrl, err)
+			return
 		}
+		isDownload = matchesMime(contentType, mimePrefixes)
+	}
+	//check if we recurse into it, or grab a file: any primary (anchor)
+	//link that isn't a download (by extension, or by MIME type when it
+	//has no extension) is a page to fetch and parse, not just ones
+	//ending in "/" like the old directory-index-only scraper required
+	if j.kind == linkPrimary && !isDownload {
+		if err := vt.Visit(newUrl); err != nil {
+			//already claimed by another worker (or a prior run)
+			return
+		}
+		//recurse in
+		childUrls, err := getUrlsFromPage(f, newUrl)
+		if err != nil {
+			fmt.Printf("Failed to get %s: %v\n", newUrl, err)
+			return
+		}
+		for i := range childUrls {
+			enqueue(crawlJob{raw: childUrls[i].URL, root: newUrl, kind: childUrls[i].Kind, depth: j.depth + 1})
+		}
+	} else {
+		if !isDownload {
+			return
+		}
+		if filter != nil {
+			if !filter.MatchString(newUrl) {
+				return
+			}
+		}
+		if !vt.ClaimDownload(newUrl) {
+			//already done, in progress, or claimed by another worker
+			return
+		}
+		fmt.Printf("Downloading %s ...", j.raw)
+		if err := f.downloadFile(newUrl, j.raw, outDir); err != nil {
+			fmt.Printf("Failed: %v\n", err)
+			vt.SetDownloadStatus(newUrl, downloadFailed, err)
+		} else {
+			fmt.Printf("DONE\n")
+			vt.SetDownloadStatus(newUrl, downloadDone, nil)
+		}
+	}
+}
+
+// fetcher performs all outbound HTTP requests, bounding total in-flight
+// requests to concurrency and rate-limiting per host so a crawl doesn't
+// overwhelm the server it's mirroring. It also owns download retry policy
+// and the optional URL -> sha256 checksum table.
+type fetcher struct {
+	sem       chan struct{}
+	limiter   *hostLimiter
+	retries   int
+	checksums map[string]string
+}
+
+func newFetcher(concurrency int, rps float64, retries int, checksums map[string]string) *fetcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &fetcher{
+		sem:       make(chan struct{}, concurrency),
+		limiter:   newHostLimiter(rps),
+		retries:   retries,
+		checksums: checksums,
 	}
 }
 
-func downloadFile(url, filename, destination string) error {
+// acquire blocks until a request slot is free and the relevant host's
+// rate limiter permits a request, returning a func to release the slot.
+func (f *fetcher) acquire(rawUrl string) func() {
+	f.sem <- struct{}{}
+	if u, err := url.Parse(rawUrl); err == nil {
+		f.limiter.wait(u.Host)
+	}
+	return func() { <-f.sem }
+}
+
+// downloadFile fetches url to destination/filename, resuming a partial
+// file via a Range request when possible, retrying transient failures
+// with exponential backoff, and verifying the result's size and (when
+// available) checksum.
+func (f *fetcher) downloadFile(url, filename, destination string) error {
 	if *simulate {
 		fmt.Printf("%s\n", url)
 		return nil
 	}
-	resp, err := http.Get(url)
+	dest := path.Join(destination, path.Base(filename))
+
+	var lastErr error
+	backoff := time.Second
+	for attempt := 0; attempt <= f.retries; attempt++ {
+		if attempt > 0 {
+			fmt.Printf("\nRetrying %s (attempt %d/%d) after %v: %v\n", url, attempt, f.retries, backoff, lastErr)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if lastErr = f.downloadAttempt(url, dest); lastErr == nil {
+			break
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return f.verifyChecksum(url, dest)
+}
+
+// downloadAttempt performs one GET of url into dest, resuming from dest's
+// current size via a Range request when the server supports it, and
+// verifying the final size against Content-Length.
+func (f *fetcher) downloadAttempt(rawUrl, dest string) error {
+	defer f.acquire(rawUrl)()
+
+	var contentLength int64 = -1
+	acceptRanges := false
+	if head, err := http.Head(rawUrl); err == nil {
+		head.Body.Close()
+		if head.StatusCode == http.StatusOK {
+			contentLength = head.ContentLength
+			acceptRanges = head.Header.Get("Accept-Ranges") == "bytes"
+		}
+	}
+
+	var offset int64
+	if fi, err := os.Stat(dest); err == nil {
+		offset = fi.Size()
+	}
+	if offset > 0 && contentLength > 0 && offset == contentLength {
+		//already fully downloaded on a previous attempt
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawUrl, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 && acceptRanges {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 		return fmt.Errorf("invalid status %s", resp.Status)
 	}
-	filename = path.Base(filename)
-	fout, err := os.Create(path.Join(destination, filename))
+
+	//only trust a 206 as actually honoring our Range request; some
+	//servers (caches/CDNs) advertise Accept-Ranges but answer a ranged
+	//GET with a full 200 body anyway, which would corrupt the file if
+	//appended to the existing partial bytes
+	flags := os.O_CREATE | os.O_TRUNC | os.O_WRONLY
+	if resp.StatusCode == http.StatusPartialContent {
+		flags = os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	} else {
+		offset = 0
+	}
+
+	fout, err := os.OpenFile(dest, flags, 0644)
 	if err != nil {
 		return err
 	}
 	defer fout.Close()
-	io.Copy(fout, resp.Body)
+
+	var reader io.Reader = resp.Body
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		total := int64(-1)
+		if resp.ContentLength >= 0 {
+			total = offset + resp.ContentLength
+		}
+		bar := pb.New64(total)
+		bar.SetWriter(os.Stderr)
+		bar.Start()
+		bar.Add64(offset)
+		reader = bar.NewProxyReader(resp.Body)
+		defer bar.Finish()
+	}
+
+	if _, err := io.Copy(fout, reader); err != nil {
+		return err
+	}
+
+	if contentLength > 0 {
+		fi, err := fout.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() != contentLength {
+			return fmt.Errorf("size mismatch: got %d bytes, want %d", fi.Size(), contentLength)
+		}
+	}
+	if err := f.verifyIntegrityHeaders(resp.Header, dest); err != nil {
+		//same size, wrong bytes: remove so the next retry re-fetches
+		//from scratch instead of treating dest as already complete
+		os.Remove(dest)
+		return err
+	}
+	return nil
+}
+
+// verifyIntegrityHeaders checks dest against a server-supplied Content-MD5
+// header, if present. ETag is not a reliable content hash across servers,
+// so it's informational only and not verified here.
+func (f *fetcher) verifyIntegrityHeaders(header http.Header, dest string) error {
+	want := header.Get("Content-MD5")
+	if want == "" {
+		return nil
+	}
+	wantBytes, err := base64.StdEncoding.DecodeString(want)
+	if err != nil {
+		//not a standard base64 Content-MD5 value, nothing reliable to compare
+		return nil
+	}
+	fin, err := os.Open(dest)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, fin); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), wantBytes) {
+		return fmt.Errorf("Content-MD5 mismatch for %s", dest)
+	}
 	return nil
 }
 
-func getPage(url string) (string, error) {
+// verifyChecksum checks dest against the -checksums table for url, if an
+// entry exists, deleting the file on mismatch.
+func (f *fetcher) verifyChecksum(url, dest string) error {
+	want, ok := f.checksums[url]
+	if !ok {
+		return nil
+	}
+	fin, err := os.Open(dest)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, fin)
+	fin.Close()
+	if err != nil {
+		return err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != want {
+		os.Remove(dest)
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", url, got, want)
+	}
+	return nil
+}
+
+func (f *fetcher) getPage(url string) (string, error) {
+	defer f.acquire(url)()
 	resp, err := http.Get(url)
 	if err != nil {
 		return "", err
@@ -179,20 +1021,122 @@ func getPage(url string) (string, error) {
 	return string(bb.Bytes()), nil
 }
 
-func newVisitTracker() *visitTracker {
-	return &visitTracker{
-		mtx: &sync.Mutex{},
-		v:   make(map[string]bool, 256),
+// contentType issues a HEAD request to learn url's Content-Type, used to
+// decide whether to download a link that has no useful file extension.
+func (f *fetcher) contentType(url string) (string, error) {
+	defer f.acquire(url)()
+	resp, err := http.Head(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("invalid status %s", resp.Status)
 	}
+	return resp.Header.Get("Content-Type"), nil
 }
 
-func (vt *visitTracker) Visited(url string) bool {
-	vt.mtx.Lock()
-	defer vt.mtx.Unlock()
-	_, ok := vt.v[url]
-	return ok
+// hostLimiter hands out a per-host rate.Limiter, lazily creating one the
+// first time a given host is seen.
+type hostLimiter struct {
+	mtx  *sync.Mutex
+	lims map[string]*rate.Limiter
+	rps  rate.Limit
+}
+
+func newHostLimiter(rps float64) *hostLimiter {
+	return &hostLimiter{
+		mtx:  &sync.Mutex{},
+		lims: make(map[string]*rate.Limiter),
+		rps:  rate.Limit(rps),
+	}
 }
 
+func (h *hostLimiter) wait(host string) {
+	h.mtx.Lock()
+	lim, ok := h.lims[host]
+	if !ok {
+		lim = rate.NewLimiter(h.rps, 1)
+		h.lims[host] = lim
+	}
+	h.mtx.Unlock()
+	lim.Wait(context.Background())
+}
+
+// newVisitTracker builds a tracker, optionally backed by a checkpoint file
+// at statePath. If the file already exists, its events are replayed to
+// restore the visited set and download statuses from a prior run.
+func newVisitTracker(statePath string) (*visitTracker, error) {
+	vt := &visitTracker{
+		mtx:       &sync.Mutex{},
+		v:         make(map[string]bool, 256),
+		downloads: make(map[string]downloadStatus, 256),
+	}
+	if statePath == "" {
+		return vt, nil
+	}
+	if err := vt.replay(statePath); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(statePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	vt.wal = wal
+	return vt, nil
+}
+
+// replay loads prior events from an existing state file, if any.
+func (vt *visitTracker) replay(statePath string) error {
+	fin, err := os.Open(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fin.Close()
+
+	scanner := bufio.NewScanner(fin)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var ev stateEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		if ev.Visit != "" {
+			vt.v[ev.Visit] = true
+		}
+		if ev.Download != "" && ev.Status != downloadInProgress {
+			//a replayed in-progress record means the run that made it is
+			//dead (we're starting fresh); don't let it block reclaiming
+			vt.downloads[ev.Download] = ev.Status
+		}
+	}
+	return scanner.Err()
+}
+
+// appendEvent writes ev to the write-ahead log, if one is configured, and
+// syncs it so a crash doesn't lose the record. Caller must hold vt.mtx.
+func (vt *visitTracker) appendEvent(ev stateEvent) {
+	if vt.wal == nil {
+		return
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	if _, err := vt.wal.Write(b); err != nil {
+		return
+	}
+	vt.wal.Sync()
+}
+
+// Visit atomically checks whether url has already been visited and, if
+// not, marks it visited. It reports an error if another caller already
+// claimed it, so concurrent workers recursing into the same page can't
+// both win the claim.
 func (vt *visitTracker) Visit(url string) error {
 	vt.mtx.Lock()
 	defer vt.mtx.Unlock()
@@ -201,5 +1145,49 @@ func (vt *visitTracker) Visit(url string) error {
 		return errors.New("Already visited")
 	}
 	vt.v[url] = true
+	vt.appendEvent(stateEvent{Visit: url})
 	return nil
 }
+
+// ClaimDownload atomically checks whether url still needs downloading and,
+// if so, marks it in-progress in the same locked section. It reports
+// whether the caller won the claim, so two workers that both discover a
+// link to the same file can't both start writing its destination path.
+func (vt *visitTracker) ClaimDownload(url string) bool {
+	vt.mtx.Lock()
+	defer vt.mtx.Unlock()
+	switch vt.downloads[url] {
+	case downloadDone, downloadInProgress:
+		return false
+	}
+	vt.downloads[url] = downloadInProgress
+	vt.appendEvent(stateEvent{Download: url, Status: downloadInProgress})
+	return true
+}
+
+// SetDownloadStatus records a file's download status, optionally with the
+// error that caused a failure, and appends the change to the state log.
+func (vt *visitTracker) SetDownloadStatus(url string, status downloadStatus, downloadErr error) {
+	vt.mtx.Lock()
+	defer vt.mtx.Unlock()
+	vt.downloads[url] = status
+	ev := stateEvent{Download: url, Status: status}
+	if downloadErr != nil {
+		ev.Err = downloadErr.Error()
+	}
+	vt.appendEvent(ev)
+}
+
+// Close flushes and closes the state file, if one is configured. Safe to
+// call more than once.
+func (vt *visitTracker) Close() error {
+	vt.mtx.Lock()
+	defer vt.mtx.Unlock()
+	if vt.wal == nil {
+		return nil
+	}
+	wal := vt.wal
+	vt.wal = nil
+	wal.Sync()
+	return wal.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import "testing"
+
+func TestScopeAllowed(t *testing.T) {
+	tests := []struct {
+		name       string
+		subdomains bool
+		maxDepth   int
+		includes   []string
+		excludes   []string
+		rawUrl     string
+		depth      int
+		want       bool
+	}{
+		{
+			name:   "same host allowed",
+			rawUrl: "http://example.com/a",
+			want:   true,
+		},
+		{
+			name:   "different host rejected",
+			rawUrl: "http://other.com/a",
+			want:   false,
+		},
+		{
+			name:       "subdomain rejected without opt-in",
+			subdomains: false,
+			rawUrl:     "http://cdn.example.com/a",
+			want:       false,
+		},
+		{
+			name:       "subdomain allowed with opt-in",
+			subdomains: true,
+			rawUrl:     "http://cdn.example.com/a",
+			want:       true,
+		},
+		{
+			name:     "within max depth",
+			maxDepth: 2,
+			rawUrl:   "http://example.com/a",
+			depth:    2,
+			want:     true,
+		},
+		{
+			name:     "past max depth rejected",
+			maxDepth: 2,
+			rawUrl:   "http://example.com/a",
+			depth:    3,
+			want:     false,
+		},
+		{
+			name:     "exclude pattern rejected",
+			excludes: []string{`/private/`},
+			rawUrl:   "http://example.com/private/a",
+			want:     false,
+		},
+		{
+			name:     "include pattern required",
+			includes: []string{`\.zip$`},
+			rawUrl:   "http://example.com/a.txt",
+			want:     false,
+		},
+		{
+			name:     "include pattern matched",
+			includes: []string{`\.zip$`},
+			rawUrl:   "http://example.com/a.zip",
+			want:     true,
+		},
+		{
+			name:     "exclude wins over include",
+			includes: []string{`\.zip$`},
+			excludes: []string{`/private/`},
+			rawUrl:   "http://example.com/private/a.zip",
+			want:     false,
+		},
+		{
+			name:   "relative url with no host allowed",
+			rawUrl: "/a.zip",
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newScope("example.com", tt.subdomains, tt.maxDepth, tt.includes, tt.excludes)
+			if err != nil {
+				t.Fatalf("newScope: %v", err)
+			}
+			if got := s.allowed(tt.rawUrl, tt.depth); got != tt.want {
+				t.Errorf("allowed(%q, %d) = %v, want %v", tt.rawUrl, tt.depth, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeAllowedNilScope(t *testing.T) {
+	var s *Scope
+	if !s.allowed("http://anything.example/whatever", 99) {
+		t.Error("nil Scope should allow everything")
+	}
+}
+
+func TestNewScopeBadPattern(t *testing.T) {
+	if _, err := newScope("example.com", false, 0, []string{"("}, nil); err == nil {
+		t.Error("expected an error compiling an invalid include pattern")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRobotsRulesAllowed(t *testing.T) {
+	var nilRules *robotsRules
+	if !nilRules.allowed("/anything") {
+		t.Error("nil robotsRules should allow everything")
+	}
+
+	rules := &robotsRules{disallow: []string{"/private/", "/admin"}}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/private/secret.zip", false},
+		{"/admin", false},
+		{"/adminish", false},
+		{"/public/file.zip", true},
+		{"/", true},
+	}
+	for _, tt := range tests {
+		if got := rules.allowed(tt.path); got != tt.want {
+			t.Errorf("allowed(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSitemapURLSetParsing(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>http://example.com/a.html</loc></url>
+	<url><loc>http://example.com/b.html</loc></url>
+</urlset>`
+	var uset sitemapURLSet
+	if err := xml.Unmarshal([]byte(body), &uset); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	got := make([]string, len(uset.URLs))
+	for i, u := range uset.URLs {
+		got[i] = u.Loc
+	}
+	want := []string{"http://example.com/a.html", "http://example.com/b.html"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSitemapIndexParsing(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>http://example.com/sitemap-1.xml</loc></sitemap>
+	<sitemap><loc>http://example.com/sitemap-2.xml</loc></sitemap>
+</sitemapindex>`
+	var idx sitemapIndex
+	if err := xml.Unmarshal([]byte(body), &idx); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(idx.Sitemaps) != 2 {
+		t.Fatalf("got %d sitemaps, want 2", len(idx.Sitemaps))
+	}
+	if idx.Sitemaps[0].Loc != "http://example.com/sitemap-1.xml" {
+		t.Errorf("got %q", idx.Sitemaps[0].Loc)
+	}
+}
+
+func TestLoadChecksumsFile(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "checksums.txt")
+	content := "# comment\n\nhttp://example.com/a.zip deadbeef\nhttp://example.com/b.zip cafef00d\nbadline\n"
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sums, err := loadChecksumsFile(p)
+	if err != nil {
+		t.Fatalf("loadChecksumsFile: %v", err)
+	}
+	want := map[string]string{
+		"http://example.com/a.zip": "deadbeef",
+		"http://example.com/b.zip": "cafef00d",
+	}
+	if !reflect.DeepEqual(sums, want) {
+		t.Errorf("got %v, want %v", sums, want)
+	}
+}
+
+func TestLoadChecksumsFileMissing(t *testing.T) {
+	if _, err := loadChecksumsFile(filepath.Join(t.TempDir(), "nope.txt")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
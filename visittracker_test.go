@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestClaimDownload(t *testing.T) {
+	vt, err := newVisitTracker("")
+	if err != nil {
+		t.Fatalf("newVisitTracker: %v", err)
+	}
+	defer vt.Close()
+
+	if !vt.ClaimDownload("http://example.com/a.zip") {
+		t.Fatal("first claim should succeed")
+	}
+	if vt.ClaimDownload("http://example.com/a.zip") {
+		t.Fatal("second concurrent claim should be refused while in progress")
+	}
+
+	vt.SetDownloadStatus("http://example.com/a.zip", downloadDone, nil)
+	if vt.ClaimDownload("http://example.com/a.zip") {
+		t.Fatal("a done download should not be reclaimable")
+	}
+}
+
+func TestReplayDropsStaleInProgress(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.log")
+
+	vt, err := newVisitTracker(statePath)
+	if err != nil {
+		t.Fatalf("newVisitTracker: %v", err)
+	}
+	if !vt.ClaimDownload("http://example.com/a.zip") {
+		t.Fatal("expected first claim to succeed")
+	}
+	//simulate a crash: close without ever recording done/failed
+	if err := vt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	resumed, err := newVisitTracker(statePath)
+	if err != nil {
+		t.Fatalf("newVisitTracker (resume): %v", err)
+	}
+	defer resumed.Close()
+
+	if !resumed.ClaimDownload("http://example.com/a.zip") {
+		t.Fatal("a stale in-progress record from a dead run should be reclaimable")
+	}
+}